@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+// chunkChecksumPartNames maps the multipart form name clients may send right
+// before a chunk's data to the hash algorithm it carries a digest for.
+var chunkChecksumPartNames = map[string]string{
+	"chunk_md5":    "md5",
+	"chunk_sha256": "sha256",
+}
+
+// chunkChecksumAlgo reports whether formName names a checksum part, and if
+// so, which algorithm it carries a digest for.
+func chunkChecksumAlgo(formName string) (algo string, ok bool) {
+	algo, ok = chunkChecksumPartNames[formName]
+	return algo, ok
+}
+
+// newChecksumHasher returns a hash.Hash for the given algorithm name.
+func newChecksumHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+}
+
+// ChecksumMismatchError is returned when a chunk or the reassembled file
+// doesn't hash to its expected, client-supplied digest. Handlers map it to a
+// distinct status code so clients know to retransmit rather than give up.
+// Subject describes what failed, e.g. "chunk 3" or "file".
+type ChecksumMismatchError struct {
+	Subject string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s", e.Subject)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"sort"
+	"path/filepath"
 	"strconv"
+	"time"
 )
 
 const (
@@ -28,7 +31,11 @@ type Chunk struct {
 	TotalFileSize int64 // in bytes
 	Filename      string
 	Data          io.Reader
-	UploadDir     string
+
+	// ChecksumAlgo and Checksum are optional. When set, StoreChunk verifies
+	// Data hashes to Checksum under ChecksumAlgo before keeping the chunk.
+	ChecksumAlgo string
+	Checksum     string
 }
 
 // ProcessChunk will parse the chunk data from the request and store in a file on disk.
@@ -38,12 +45,7 @@ func ProcessChunk(r *http.Request) error {
 		return fmt.Errorf("failed to parse chunk %w", err)
 	}
 
-	// Let's create the dir to store the file chunks.
-	if err := os.MkdirAll(chunk.UploadID, 02750); err != nil {
-		return err
-	}
-
-	if err := StoreChunk(chunk); err != nil {
+	if err := sessionManager.StoreChunk(chunk); err != nil {
 		return err
 	}
 
@@ -51,11 +53,15 @@ func ProcessChunk(r *http.Request) error {
 }
 
 // CompleteChunk rebulds the file chunks into the original full file.
-// It then stores the file on disk.
-func CompleteChunk(uploadID, filename string) error {
-	uploadDir := fmt.Sprintf("%s/%s", uploadDir, uploadID)
+// It then stores the file on disk. If fileChecksum is non-empty, the
+// reassembled file is hashed with sha256 and verified against it before the
+// file is renamed into place; on mismatch the partial file is discarded.
+func CompleteChunk(uploadID, filename, fileChecksum string) error {
+	if err := validateUploadComplete(uploadID); err != nil {
+		return err
+	}
 
-	f, err := RebuildFile(uploadDir)
+	f, err := store.Finalize(uploadID)
 	if err != nil {
 		return fmt.Errorf("failed to rebuild file %w", err)
 	}
@@ -67,16 +73,37 @@ func CompleteChunk(uploadID, filename string) error {
 	// or do any processing we want such as resizing, tagging, storing in a cloud storage.
 	// to keep this simple we'll just store the file on disk.
 
-	newFile, err := os.Create(filename)
+	// Create the temp file in filename's own directory, not the OS temp dir:
+	// os.Rename below fails across devices, and /tmp is often a separate
+	// filesystem from the destination.
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "completed-")
 	if err != nil {
-		return fmt.Errorf("failed creating file %w", err)
+		return fmt.Errorf("failed creating temp file %w", err)
 	}
-	defer newFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
-	if _, err := io.Copy(newFile, f); err != nil {
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hasher), f); err != nil {
 		return fmt.Errorf("failed copying file contents %w", err)
 	}
 
+	if fileChecksum != "" {
+		if got := hex.EncodeToString(hasher.Sum(nil)); got != fileChecksum {
+			return &ChecksumMismatchError{Subject: "file"}
+		}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed closing temp file %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		return fmt.Errorf("failed renaming file into place %w", err)
+	}
+
+	sessionManager.Forget(uploadID)
+
 	return nil
 }
 
@@ -108,9 +135,6 @@ func ParseChunk(r *http.Request) (*Chunk, error) {
 	chunk.UploadID = buf.String()
 	buf.Reset()
 
-	// dir to where we store our chunk
-	chunk.UploadDir = fmt.Sprintf("%s/%s", uploadDir, chunk.UploadID)
-
 	// 2
 	if err := getPart("chunk_number", reader, buf); err != nil {
 		return nil, err
@@ -158,80 +182,77 @@ func ParseChunk(r *http.Request) (*Chunk, error) {
 	chunk.Filename = buf.String()
 	buf.Reset()
 
-	// 6
+	// 6 (optional): chunk_md5 or chunk_sha256, the expected digest of the chunk data part that follows.
 	part, err := reader.NextPart()
 	if err != nil {
 		return nil, fmt.Errorf("failed reading chunk part %w", err)
 	}
 
-	chunk.Data = part
-
-	return &chunk, nil
-}
+	if algo, ok := chunkChecksumAlgo(part.FormName()); ok {
+		if _, err := io.Copy(buf, part); err != nil {
+			return nil, fmt.Errorf("failed copying %s part %w", part.FormName(), err)
+		}
 
-// StoreChunk stores the chunk on disk for it to later be processed when all other file chunks have been uploaded.
-func StoreChunk(chunk *Chunk) error {
-	chunkFile, err := os.Create(fmt.Sprintf("%s/%d", chunk.UploadDir, chunk.ChunkNumber))
-	if err != nil {
-		return err
-	}
+		chunk.ChecksumAlgo = algo
+		chunk.Checksum = buf.String()
+		buf.Reset()
 
-	if _, err := io.CopyN(chunkFile, chunk.Data, maxChunkSize); err != nil && err != io.EOF {
-		return err
+		part, err = reader.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading chunk part %w", err)
+		}
 	}
 
-	return nil
-}
-
-// ByChunk is a helper type to sort the files by name. Since the name of the file is it's chunk number
-// it makes rebuilding the file a trivial task.
-type ByChunk []os.FileInfo
+	chunk.Data = part
 
-func (a ByChunk) Len() int      { return len(a) }
-func (a ByChunk) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a ByChunk) Less(i, j int) bool {
-	ai, _ := strconv.Atoi(a[i].Name())
-	aj, _ := strconv.Atoi(a[j].Name())
-	return ai < aj
+	return &chunk, nil
 }
 
-// RebuildFile grabs all the files from the directory passed on concantinates them to build the original file.
-// It stores the file contents in a temp file and returns it.
-func RebuildFile(dir string) (*os.File, error) {
-	fileInfos, err := ioutil.ReadDir(uploadDir)
-	if err != nil {
-		return nil, err
+// StoreChunk hands the chunk data off to the configured ChunkStore, to be
+// assembled later once all of a file's chunks have been uploaded. If the
+// chunk carries a checksum, the data is verified against it as it's written;
+// on mismatch the chunk is discarded so the client can retransmit it. It also
+// persists the upload's metadata so a client that loses connectivity can
+// query GET /uploads/{id} to see what's already been received.
+func StoreChunk(chunk *Chunk) error {
+	createdAt := time.Now()
+	if existing, err := store.Meta(chunk.UploadID); err == nil {
+		createdAt = existing.CreatedAt
 	}
 
-	fullFile, err := ioutil.TempFile("", "fullfile-")
-	if err != nil {
-		return nil, err
+	meta := UploadMeta{
+		UploadID:      chunk.UploadID,
+		TotalChunks:   chunk.TotalChunks,
+		TotalFileSize: chunk.TotalFileSize,
+		Filename:      chunk.Filename,
+		CreatedAt:     createdAt,
 	}
-
-	sort.Sort(ByChunk(fileInfos))
-	for _, fs := range fileInfos {
-		if err := appendChunk(uploadDir, fs, fullFile); err != nil {
-			return nil, err
-		}
+	if err := store.SaveMeta(meta); err != nil {
+		return fmt.Errorf("failed saving upload metadata %w", err)
 	}
 
-	if err := os.RemoveAll(uploadDir); err != nil {
-		return nil, err
-	}
+	data := io.LimitReader(chunk.Data, maxChunkSize)
 
-	return fullFile, nil
-}
+	if chunk.Checksum == "" {
+		return store.Put(chunk.UploadID, chunk.ChunkNumber, data)
+	}
 
-func appendChunk(uploadDir string, fs os.FileInfo, fullFile *os.File) error {
-	src, err := os.Open(uploadDir + "/" + fs.Name())
+	hasher, err := newChecksumHasher(chunk.ChecksumAlgo)
 	if err != nil {
 		return err
 	}
-	defer src.Close()
-	if _, err := io.Copy(fullFile, src); err != nil {
+
+	if err := store.Put(chunk.UploadID, chunk.ChunkNumber, io.TeeReader(data, hasher)); err != nil {
 		return err
 	}
 
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != chunk.Checksum {
+		if err := store.Remove(chunk.UploadID, chunk.ChunkNumber); err != nil {
+			return err
+		}
+		return &ChecksumMismatchError{Subject: fmt.Sprintf("chunk %d", chunk.ChunkNumber)}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// ChunkInfo describes a single stored chunk.
+type ChunkInfo struct {
+	Number int32
+	Size   int64
+}
+
+// UploadMeta describes the upload as a whole, independent of how many chunks
+// have arrived so far. It lets a status query or a restarted server answer
+// "how much is left" without the client having to resend this information.
+type UploadMeta struct {
+	UploadID      string    `json:"uploadId"`
+	TotalChunks   int32     `json:"totalChunks"`
+	TotalFileSize int64     `json:"totalFileSize"`
+	Filename      string    `json:"filename"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// ChunkStore abstracts where chunk data lives while an upload is in progress
+// and how it gets assembled into the final file. FSChunkStore backs the
+// default multipart and tus flows by writing chunks to local disk;
+// S3ChunkStore instead drives an S3 multipart upload so chunk data never
+// touches local disk at all.
+type ChunkStore interface {
+	// Put stores the data for chunk n of uploadID.
+	Put(uploadID string, n int32, r io.Reader) error
+
+	// List returns every chunk currently stored for uploadID.
+	List(uploadID string) ([]ChunkInfo, error)
+
+	// Open returns a reader over the stored data for chunk n of uploadID.
+	Open(uploadID string, n int32) (io.ReadCloser, error)
+
+	// Delete removes every chunk stored for uploadID.
+	Delete(uploadID string) error
+
+	// Remove discards a single chunk, e.g. after it fails checksum
+	// validation, so the client can retransmit just that chunk.
+	Remove(uploadID string, n int32) error
+
+	// Finalize assembles every chunk stored for uploadID into a single file
+	// and returns a reader over its contents.
+	Finalize(uploadID string) (io.ReadCloser, error)
+
+	// SaveMeta persists meta for its UploadID so it can be read back by Meta,
+	// even across a server restart.
+	SaveMeta(meta UploadMeta) error
+
+	// Meta returns the metadata previously saved for uploadID.
+	Meta(uploadID string) (*UploadMeta, error)
+}
+
+// store is the ChunkStore backing the HTTP handlers. newStore picks the
+// backend at build time: the default build only links in FSChunkStore,
+// while building with -tags s3 swaps in an S3-backed store instead (see
+// store_s3.go).
+var store ChunkStore = newStore()
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// assemblyConcurrency caps how many chunk files Finalize reads concurrently
+// when reassembling the final file. It defaults to one worker per CPU and can
+// be overridden by main through the --assembly-concurrency flag.
+var assemblyConcurrency = runtime.NumCPU()
+
+// FSChunkStore is the default ChunkStore. It writes each chunk to its own
+// numbered file under <baseDir>/<uploadID> and tracks chunk sizes in a
+// manifest.json sidecar so Finalize can compute offsets without stat'ing the
+// whole directory.
+type FSChunkStore struct {
+	baseDir string
+}
+
+// NewFSChunkStore returns a ChunkStore that keeps chunks under baseDir.
+func NewFSChunkStore(baseDir string) *FSChunkStore {
+	return &FSChunkStore{baseDir: baseDir}
+}
+
+func (s *FSChunkStore) dir(uploadID string) string {
+	return fmt.Sprintf("%s/%s", s.baseDir, uploadID)
+}
+
+// Put writes r to chunk n's file, creating the upload directory if needed,
+// and records its size in the manifest.
+func (s *FSChunkStore) Put(uploadID string, n int32, r io.Reader) error {
+	dir := s.dir(uploadID)
+
+	if err := os.MkdirAll(dir, 02750); err != nil {
+		return err
+	}
+
+	chunkFile, err := os.Create(fmt.Sprintf("%s/%d", dir, n))
+	if err != nil {
+		return err
+	}
+	defer chunkFile.Close()
+
+	written, err := io.Copy(chunkFile, r)
+	if err != nil {
+		return err
+	}
+
+	if err := recordChunkSize(dir, n, written); err != nil {
+		return fmt.Errorf("failed recording chunk size %w", err)
+	}
+
+	return nil
+}
+
+// List returns every chunk recorded in the upload's manifest.
+func (s *FSChunkStore) List(uploadID string) ([]ChunkInfo, error) {
+	manifest, err := loadManifest(s.dir(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]ChunkInfo, 0, len(manifest))
+	for number, size := range manifest {
+		chunks = append(chunks, ChunkInfo{Number: number, Size: size})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Number < chunks[j].Number })
+
+	return chunks, nil
+}
+
+// Open returns a reader over chunk n's stored data.
+func (s *FSChunkStore) Open(uploadID string, n int32) (io.ReadCloser, error) {
+	return os.Open(fmt.Sprintf("%s/%d", s.dir(uploadID), n))
+}
+
+// Delete removes the upload directory and every chunk in it.
+func (s *FSChunkStore) Delete(uploadID string) error {
+	return os.RemoveAll(s.dir(uploadID))
+}
+
+// Remove deletes chunk n's file and its manifest entry, leaving the rest of
+// the upload untouched so the client can retransmit just that chunk.
+func (s *FSChunkStore) Remove(uploadID string, n int32) error {
+	dir := s.dir(uploadID)
+
+	if err := os.Remove(fmt.Sprintf("%s/%d", dir, n)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	delete(manifest, n)
+
+	return saveManifest(dir, manifest)
+}
+
+// SaveMeta persists meta as meta.json in the upload directory, creating it
+// if this is the first thing written for the upload.
+func (s *FSChunkStore) SaveMeta(meta UploadMeta) error {
+	dir := s.dir(meta.UploadID)
+
+	if err := os.MkdirAll(dir, 02750); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dir + "/meta.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(meta)
+}
+
+// Meta reads back the metadata previously saved for uploadID.
+func (s *FSChunkStore) Meta(uploadID string) (*UploadMeta, error) {
+	f, err := os.Open(s.dir(uploadID) + "/meta.json")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta UploadMeta
+	if err := json.NewDecoder(f).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// Finalize reassembles every chunk for uploadID into a temp file. It
+// pre-allocates the destination to its final size and writes chunks to their
+// offsets from a bounded pool of workers, so multiple chunks are read and
+// written concurrently rather than copied one after another.
+func (s *FSChunkStore) Finalize(uploadID string) (io.ReadCloser, error) {
+	dir := s.dir(uploadID)
+
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading chunk manifest %w", err)
+	}
+
+	offsets := make([]chunkOffset, 0, len(manifest))
+	for number, size := range manifest {
+		offsets = append(offsets, chunkOffset{number: number, size: size})
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].number < offsets[j].number })
+
+	var totalSize int64
+	for i := range offsets {
+		offsets[i].offset = totalSize
+		totalSize += offsets[i].size
+	}
+
+	fullFile, err := ioutil.TempFile("", "fullfile-")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fullFile.Truncate(totalSize); err != nil {
+		return nil, fmt.Errorf("failed preallocating file %w", err)
+	}
+
+	if err := writeChunksConcurrently(dir, offsets, fullFile); err != nil {
+		return nil, err
+	}
+
+	if _, err := fullFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err := s.Delete(uploadID); err != nil {
+		return nil, err
+	}
+
+	return fullFile, nil
+}
+
+// chunkOffset pairs a chunk number with where its data belongs in the
+// reassembled file.
+type chunkOffset struct {
+	number int32
+	offset int64
+	size   int64
+}
+
+// writeChunksConcurrently copies each chunk file into dst at its computed
+// offset, running up to assemblyConcurrency chunks at a time.
+func writeChunksConcurrently(dir string, offsets []chunkOffset, dst *os.File) error {
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, assemblyConcurrency)
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for _, c := range offsets {
+		c := c
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := writeChunkAt(dir, c, dst); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func writeChunkAt(dir string, c chunkOffset, dst *os.File) error {
+	src, err := os.Open(fmt.Sprintf("%s/%d", dir, c.number))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Stream the chunk straight to its offset instead of buffering the whole
+	// thing in memory: a tus upload's single chunk file has no size cap, so
+	// a large upload could otherwise make Finalize allocate gigabytes.
+	if _, err := io.Copy(io.NewOffsetWriter(dst, c.offset), src); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// chunkManifest tracks the size of every chunk written for an upload, keyed
+// by chunk number, so Finalize can compute offsets without stat'ing the whole
+// directory.
+type chunkManifest map[int32]int64
+
+func loadManifest(dir string) (chunkManifest, error) {
+	manifest := make(chunkManifest)
+
+	f, err := os.Open(dir + "/manifest.json")
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func saveManifest(dir string, manifest chunkManifest) error {
+	f, err := os.Create(dir + "/manifest.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(manifest)
+}
+
+// recordChunkSize updates the manifest for dir with the size of chunkNumber.
+func recordChunkSize(dir string, chunkNumber int32, size int64) error {
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	manifest[chunkNumber] = size
+
+	return saveManifest(dir, manifest)
+}
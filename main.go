@@ -2,21 +2,43 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"runtime"
+	"time"
 )
 
 func main() {
+	concurrency := flag.Int("assembly-concurrency", runtime.NumCPU(), "number of chunks to assemble concurrently when rebuilding an uploaded file")
+	uploadTTL := flag.Duration("upload-ttl", 24*time.Hour, "how long an upload can sit inactive before the janitor deletes it")
+	janitorInterval := flag.Duration("janitor-interval", time.Hour, "how often the janitor scans for abandoned uploads")
+	flag.Parse()
+
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	assemblyConcurrency = *concurrency
+
+	StartJanitor(*janitorInterval, *uploadTTL, nil)
+
 	http.Handle("/upload-chunk", handleUploadChunk())
 	http.Handle("/completed-chunks", handleCompletedChunk())
 
+	// tus.io resumable upload protocol, offered alongside the multipart flow above.
+	http.Handle("/files", handleTusCreate())
+	http.Handle("/files/", handleTusFiles())
+
+	http.Handle("/uploads/", handleUploadStatus())
+
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
 func handleUploadChunk() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := ProcessChunk(r); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeChunkError(w, err)
 			return
 		}
 
@@ -25,8 +47,9 @@ func handleUploadChunk() http.Handler {
 }
 func handleCompletedChunk() http.Handler {
 	type request struct {
-		UploadID string `json:"uploadId"`
-		Filename string `json:"filename"`
+		UploadID     string `json:"uploadId"`
+		Filename     string `json:"filename"`
+		FileChecksum string `json:"fileChecksum"` // optional sha256 hex digest of the reassembled file.
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,11 +61,36 @@ func handleCompletedChunk() http.Handler {
 
 		// validate payload
 
-		if err := CompleteChunk(payload.UploadID, payload.Filename); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := CompleteChunk(payload.UploadID, payload.Filename, payload.FileChecksum); err != nil {
+			writeChunkError(w, err)
 			return
 		}
 
 		w.Write([]byte("file processed"))
 	})
 }
+
+// writeChunkError maps known chunk-processing errors to a distinct status
+// code so clients can tell a checksum mismatch (retransmit) apart from a
+// generic server failure.
+func writeChunkError(w http.ResponseWriter, err error) {
+	var checksumErr *ChecksumMismatchError
+	if errors.As(err, &checksumErr) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var incompleteErr *IncompleteUploadError
+	if errors.As(err, &incompleteErr) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	var duplicateErr *DuplicateChunkError
+	if errors.As(err, &duplicateErr) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,263 @@
+//go:build s3
+
+// This file depends on the AWS SDK, which isn't vendored into this module by
+// default. Build with -tags s3 (and a go.sum that includes the SDK) to
+// compile it in.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3ChunkStore is a ChunkStore backed by S3's native multipart upload API.
+// Each chunk becomes an UploadPart call against a multipart upload created on
+// the first Put, so chunk data never touches local disk and Finalize is just
+// a CompleteMultipartUpload call instead of a local concatenation.
+type S3ChunkStore struct {
+	client *s3.Client
+	bucket string
+
+	mu      sync.Mutex
+	uploads map[string]*s3MultipartUpload
+}
+
+// s3MultipartUpload tracks the state of one in-progress multipart upload.
+// ready is closed once uploadID has been populated by the CreateMultipartUpload
+// call that initialized this entry, so a second caller arriving while that
+// call is in flight waits for it instead of issuing its own.
+type s3MultipartUpload struct {
+	uploadID string
+	parts    []types.CompletedPart
+	meta     *UploadMeta
+	ready    chan struct{}
+}
+
+// NewS3ChunkStore returns a ChunkStore that stores chunks as parts of an S3
+// multipart upload in bucket.
+func NewS3ChunkStore(client *s3.Client, bucket string) *S3ChunkStore {
+	return &S3ChunkStore{
+		client:  client,
+		bucket:  bucket,
+		uploads: make(map[string]*s3MultipartUpload),
+	}
+}
+
+// Put uploads r as part n of uploadID's multipart upload, creating the
+// multipart upload on the first call.
+func (s *S3ChunkStore) Put(uploadID string, n int32, r io.Reader) error {
+	ctx := context.Background()
+
+	upload, err := s.multipartUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// S3 part numbers start at 1, our chunk numbers start at 0.
+	partNumber := aws.Int32(n + 1)
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed reading chunk data %w", err)
+	}
+
+	out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(uploadID),
+		UploadId:   aws.String(upload.uploadID),
+		PartNumber: partNumber,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed uploading part %d %w", n, err)
+	}
+
+	s.mu.Lock()
+	upload.parts = append(upload.parts, types.CompletedPart{
+		ETag:       out.ETag,
+		PartNumber: partNumber,
+	})
+	s.mu.Unlock()
+
+	return nil
+}
+
+// List returns every part uploaded so far for uploadID.
+func (s *S3ChunkStore) List(uploadID string) ([]ChunkInfo, error) {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no multipart upload found for %s", uploadID)
+	}
+
+	out, err := s.client.ListParts(context.Background(), &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(uploadID),
+		UploadId: aws.String(upload.uploadID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing parts %w", err)
+	}
+
+	chunks := make([]ChunkInfo, 0, len(out.Parts))
+	for _, part := range out.Parts {
+		chunks = append(chunks, ChunkInfo{
+			Number: aws.ToInt32(part.PartNumber) - 1,
+			Size:   aws.ToInt64(part.Size),
+		})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Number < chunks[j].Number })
+
+	return chunks, nil
+}
+
+// Open is not supported by S3ChunkStore: individual parts of an in-progress
+// multipart upload cannot be read back from S3.
+func (s *S3ChunkStore) Open(uploadID string, n int32) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("S3ChunkStore: reading individual chunks is not supported")
+}
+
+// Delete aborts the multipart upload for uploadID, discarding any parts
+// already uploaded.
+func (s *S3ChunkStore) Delete(uploadID string) error {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	_, err := s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(uploadID),
+		UploadId: aws.String(upload.uploadID),
+	})
+
+	return err
+}
+
+// SaveMeta keeps meta alongside the in-progress multipart upload's state so
+// Meta can answer status queries while the upload is active. It creates the
+// multipart upload if this is called before the first Put.
+func (s *S3ChunkStore) SaveMeta(meta UploadMeta) error {
+	upload, err := s.multipartUpload(context.Background(), meta.UploadID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	upload.meta = &meta
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Meta returns the metadata previously saved for uploadID.
+func (s *S3ChunkStore) Meta(uploadID string) (*UploadMeta, error) {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+	if !ok || upload.meta == nil {
+		return nil, fmt.Errorf("no metadata found for %s", uploadID)
+	}
+
+	return upload.meta, nil
+}
+
+// Remove is a no-op for S3ChunkStore: S3 has no API to drop a single part of
+// an in-progress multipart upload, but calling UploadPart again for the same
+// part number simply overwrites it, so the client can just retransmit.
+func (s *S3ChunkStore) Remove(uploadID string, n int32) error {
+	return nil
+}
+
+// Finalize completes the multipart upload and returns a reader over the
+// resulting object.
+func (s *S3ChunkStore) Finalize(uploadID string) (io.ReadCloser, error) {
+	ctx := context.Background()
+
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no multipart upload found for %s", uploadID)
+	}
+
+	parts := make([]types.CompletedPart, len(upload.parts))
+	copy(parts, upload.parts)
+	sort.Slice(parts, func(i, j int) bool { return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber) })
+
+	if _, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(uploadID),
+		UploadId: aws.String(upload.uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed completing multipart upload %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(uploadID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching assembled object %w", err)
+	}
+
+	return obj.Body, nil
+}
+
+// multipartUpload returns the in-progress multipart upload for uploadID,
+// creating one against S3 if this is the first chunk seen for it. Concurrent
+// first calls for the same uploadID don't race: only the caller that
+// actually inserts the map entry issues CreateMultipartUpload, and everyone
+// else waits on upload.ready instead of creating (and leaking) a second one.
+func (s *S3ChunkStore) multipartUpload(ctx context.Context, uploadID string) (*s3MultipartUpload, error) {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		upload = &s3MultipartUpload{ready: make(chan struct{})}
+		s.uploads[uploadID] = upload
+	}
+	s.mu.Unlock()
+
+	if ok {
+		<-upload.ready
+		return upload, nil
+	}
+
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(uploadID),
+	})
+	if err != nil {
+		s.mu.Lock()
+		delete(s.uploads, uploadID)
+		s.mu.Unlock()
+		close(upload.ready)
+		return nil, fmt.Errorf("failed creating multipart upload %w", err)
+	}
+
+	s.mu.Lock()
+	upload.uploadID = aws.ToString(out.UploadId)
+	s.mu.Unlock()
+	close(upload.ready)
+
+	return upload, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionDir holds one small JSON file per in-progress upload, recording only
+// enough to let the janitor find abandoned uploads across a restart.
+const sessionDir = "./data/sessions"
+
+// Session serializes writes to a single upload and tracks which chunk
+// numbers it has already accepted, so two concurrent requests for the same
+// (uploadID, chunkNumber) can't race each other on disk.
+type Session struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	seen         map[int32]bool
+}
+
+// SessionManager owns one Session per active upload.
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionManager returns an empty SessionManager.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{sessions: make(map[string]*Session)}
+}
+
+// sessionManager is the process-wide manager backing ProcessChunk.
+var sessionManager = NewSessionManager()
+
+func (m *SessionManager) session(uploadID string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[uploadID]
+	if !ok {
+		s = &Session{seen: make(map[int32]bool)}
+		m.sessions[uploadID] = s
+	}
+
+	return s
+}
+
+// Lock acquires uploadID's session lock and returns a func to release it, so
+// callers outside the multipart StoreChunk path (the tus PATCH handler, in
+// particular) can serialize their own read-modify-write sequence against the
+// same upload.
+func (m *SessionManager) Lock(uploadID string) func() {
+	s := m.session(uploadID)
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// Forget drops an upload's in-memory session and its persisted state, once
+// the upload has completed or been cancelled.
+func (m *SessionManager) Forget(uploadID string) {
+	m.mu.Lock()
+	delete(m.sessions, uploadID)
+	m.mu.Unlock()
+
+	os.Remove(sessionFilePath(uploadID))
+}
+
+// DuplicateChunkError is returned when a chunk number has already been
+// accepted for an upload.
+type DuplicateChunkError struct {
+	UploadID    string
+	ChunkNumber int32
+}
+
+func (e *DuplicateChunkError) Error() string {
+	return fmt.Sprintf("chunk %d already received for upload %s", e.ChunkNumber, e.UploadID)
+}
+
+// StoreChunk serializes writes to chunk.UploadID through its session,
+// rejecting a chunk number that's already been accepted, and records the
+// session's last activity to disk for the janitor.
+func (m *SessionManager) StoreChunk(chunk *Chunk) error {
+	s := m.session(chunk.UploadID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen[chunk.ChunkNumber] {
+		return &DuplicateChunkError{UploadID: chunk.UploadID, ChunkNumber: chunk.ChunkNumber}
+	}
+
+	if err := StoreChunk(chunk); err != nil {
+		return err
+	}
+
+	s.seen[chunk.ChunkNumber] = true
+	s.lastActivity = time.Now()
+
+	return saveSessionState(chunk.UploadID, s.lastActivity)
+}
+
+// sessionState is the JSON sidecar persisted for each active upload so the
+// janitor can find abandoned uploads even after a server restart.
+type sessionState struct {
+	UploadID     string    `json:"uploadId"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+func sessionFilePath(uploadID string) string {
+	return sessionDir + "/" + uploadID + ".json"
+}
+
+func saveSessionState(uploadID string, lastActivity time.Time) error {
+	if err := os.MkdirAll(sessionDir, 02750); err != nil {
+		return err
+	}
+
+	f, err := os.Create(sessionFilePath(uploadID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(sessionState{UploadID: uploadID, LastActivity: lastActivity})
+}
+
+func loadSessionState(uploadID string) (*sessionState, error) {
+	f, err := os.Open(sessionFilePath(uploadID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var state sessionState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// StartJanitor launches a goroutine that periodically deletes uploads whose
+// session has been inactive longer than ttl, until stop is closed. It reads
+// session state from disk rather than the in-memory SessionManager so
+// abandoned uploads are still cleaned up after a server restart.
+func StartJanitor(interval, ttl time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sweepAbandonedUploads(ttl)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// sweepAbandonedUploads deletes every upload whose last recorded activity is
+// older than ttl. It also catches upload directories that never got a
+// session file at all -- e.g. a multipart upload whose first StoreChunk
+// failed after SaveMeta had already created the directory -- by falling back
+// to the directory's own modification time for anything not covered by a
+// session file.
+func sweepAbandonedUploads(ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+	tracked := make(map[string]bool)
+
+	if entries, err := os.ReadDir(sessionDir); err == nil {
+		for _, entry := range entries {
+			uploadID := strings.TrimSuffix(entry.Name(), ".json")
+			tracked[uploadID] = true
+
+			state, err := loadSessionState(uploadID)
+			if err != nil {
+				continue
+			}
+
+			if state.LastActivity.Before(cutoff) {
+				store.Delete(uploadID)
+				sessionManager.Forget(uploadID)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	dirs, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range dirs {
+		if !entry.IsDir() || tracked[entry.Name()] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		store.Delete(entry.Name())
+		sessionManager.Forget(entry.Name())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// uploadStatus is the JSON shape returned by GET /uploads/{id}. A client that
+// lost connectivity mid-upload can fetch this to see which chunks the server
+// already has and only retransmit what's missing, instead of starting over.
+type uploadStatus struct {
+	UploadID      string      `json:"uploadId"`
+	TotalChunks   int32       `json:"totalChunks"`
+	TotalFileSize int64       `json:"totalFileSize"`
+	Chunks        []ChunkInfo `json:"chunks"`
+}
+
+// handleUploadStatus implements GET /uploads/{id}.
+func handleUploadStatus() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		uploadID := strings.TrimPrefix(r.URL.Path, "/uploads/")
+		if uploadID == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		meta, err := store.Meta(uploadID)
+		if err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+
+		chunks, err := store.List(uploadID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		status := uploadStatus{
+			UploadID:      meta.UploadID,
+			TotalChunks:   meta.TotalChunks,
+			TotalFileSize: meta.TotalFileSize,
+			Chunks:        chunks,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// IncompleteUploadError is returned when CompleteChunk is asked to assemble
+// an upload that's missing one or more expected chunks.
+type IncompleteUploadError struct {
+	UploadID string
+	Reason   string
+}
+
+func (e *IncompleteUploadError) Error() string {
+	return fmt.Sprintf("upload %s is incomplete: %s", e.UploadID, e.Reason)
+}
+
+// validateUploadComplete checks that every chunk expected by the upload's
+// metadata is present with its recorded size, before CompleteChunk attempts
+// to assemble them.
+func validateUploadComplete(uploadID string) error {
+	meta, err := store.Meta(uploadID)
+	if err != nil {
+		return fmt.Errorf("failed loading upload metadata %w", err)
+	}
+
+	chunks, err := store.List(uploadID)
+	if err != nil {
+		return fmt.Errorf("failed listing chunks %w", err)
+	}
+
+	if len(chunks) == 0 {
+		return &IncompleteUploadError{UploadID: uploadID, Reason: "no chunks received"}
+	}
+
+	// A positive TotalChunks comes from the multipart flow, which knows the
+	// chunk count upfront. The tus flow doesn't, so it leaves TotalChunks
+	// unset and we fall back to checking contiguity and total size below.
+	if meta.TotalChunks > 0 && int32(len(chunks)) != meta.TotalChunks {
+		return &IncompleteUploadError{
+			UploadID: uploadID,
+			Reason:   fmt.Sprintf("expected %d chunks, have %d", meta.TotalChunks, len(chunks)),
+		}
+	}
+
+	// Chunk numbering isn't guaranteed to start at 0: some clients (e.g.
+	// flow.js) number chunks from 1. So contiguity is checked against the
+	// range actually present, rather than assuming a 0-based start.
+	seen := make(map[int32]bool, len(chunks))
+	minNumber, maxNumber := chunks[0].Number, chunks[0].Number
+	var totalSize int64
+	for _, c := range chunks {
+		seen[c.Number] = true
+		totalSize += c.Size
+		if c.Number < minNumber {
+			minNumber = c.Number
+		}
+		if c.Number > maxNumber {
+			maxNumber = c.Number
+		}
+	}
+
+	for n := minNumber; n <= maxNumber; n++ {
+		if !seen[n] {
+			return &IncompleteUploadError{
+				UploadID: uploadID,
+				Reason:   fmt.Sprintf("missing chunk %d", n),
+			}
+		}
+	}
+
+	if totalSize != meta.TotalFileSize {
+		return &IncompleteUploadError{
+			UploadID: uploadID,
+			Reason:   fmt.Sprintf("expected %d bytes, have %d", meta.TotalFileSize, totalSize),
+		}
+	}
+
+	return nil
+}
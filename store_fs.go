@@ -0,0 +1,10 @@
+//go:build !s3
+
+package main
+
+// newStore returns the ChunkStore backend selected at build time. The
+// default build only includes the filesystem backend; build with -tags s3
+// to link in the S3-backed store instead (see store_s3.go).
+func newStore() ChunkStore {
+	return NewFSChunkStore(uploadDir)
+}
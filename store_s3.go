@@ -0,0 +1,31 @@
+//go:build s3
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newStore returns the ChunkStore backend selected at build time. Built with
+// -tags s3, it swaps in the S3-backed store so chunk data never touches
+// local disk, letting uploads scale beyond a single box. The bucket is
+// configured through UPLOAD_S3_BUCKET; AWS credentials and region come from
+// the usual AWS_* / shared config environment.
+func newStore() ChunkStore {
+	bucket := os.Getenv("UPLOAD_S3_BUCKET")
+	if bucket == "" {
+		log.Fatal("UPLOAD_S3_BUCKET must be set when built with -tags s3")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatalf("failed loading AWS config: %v", err)
+	}
+
+	return NewS3ChunkStore(s3.NewFromConfig(cfg), bucket)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tusResumableVersion is the protocol version this server implements.
+const tusResumableVersion = "1.0.0"
+
+// tusExtensions lists the optional tus extensions this server supports.
+const tusExtensions = "creation,termination,checksum"
+
+// tusUpload is the sidecar state we persist for an upload created through the
+// tus endpoints. It lives at <uploadDir>/<UploadID>/info.json so an upload can
+// be resumed even if the server restarts mid-transfer.
+type tusUpload struct {
+	UploadID  string            `json:"uploadId"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// handleTusCreate implements the tus creation extension: POST /files. OPTIONS
+// is also routed here (tus clients probe capabilities at the same URL they
+// create uploads against), so it's handed off to handleTusOptions.
+func handleTusCreate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			handleTusOptions().ServeHTTP(w, r)
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			http.Error(w, "missing or invalid Upload-Length header", http.StatusBadRequest)
+			return
+		}
+
+		metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		uploadID, err := generateUploadID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dir := fmt.Sprintf("%s/%s", uploadDir, uploadID)
+		if err := os.MkdirAll(dir, 02750); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		upload := tusUpload{
+			UploadID:  uploadID,
+			Length:    length,
+			Metadata:  metadata,
+			CreatedAt: time.Now(),
+		}
+		if err := saveTusUpload(dir, &upload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Record activity now, not just on the first PATCH, so an upload
+		// that's created and then abandoned before any chunk arrives still
+		// gets swept by the janitor.
+		if err := saveSessionState(uploadID, upload.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		setTusHeaders(w)
+		w.Header().Set("Location", "/files/"+uploadID)
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// handleTusHead implements HEAD /files/{id}, reporting how many bytes the
+// server has already received so a client knows where to resume from.
+func handleTusHead(uploadID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := fmt.Sprintf("%s/%s", uploadDir, uploadID)
+
+		upload, err := loadTusUpload(dir)
+		if err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+
+		setTusHeaders(w)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// handleTusPatch implements PATCH /files/{id}: it appends the request body to
+// the upload as a new numbered chunk and advances the offset. Once the offset
+// reaches the declared length it hands off to the existing CompleteChunk path.
+func handleTusPatch(uploadID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			http.Error(w, "unsupported Content-Type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// Hold this upload's session lock for the whole read-check-write
+		// sequence below, so two concurrent PATCHes for the same upload
+		// can't both read the same offset, both pass the offset check, and
+		// both claim the same chunk number.
+		unlock := sessionManager.Lock(uploadID)
+		defer unlock()
+
+		dir := fmt.Sprintf("%s/%s", uploadDir, uploadID)
+
+		upload, err := loadTusUpload(dir)
+		if err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "missing or invalid Upload-Offset header", http.StatusBadRequest)
+			return
+		}
+
+		if offset != upload.Offset {
+			http.Error(w, "upload offset mismatch", http.StatusConflict)
+			return
+		}
+
+		body := io.Reader(r.Body)
+
+		var (
+			hasher  = sha1.New()
+			wantSum string
+		)
+		if checksum := r.Header.Get("Upload-Checksum"); checksum != "" {
+			algo, sum, err := parseUploadChecksum(checksum)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if algo != "sha1" {
+				http.Error(w, "unsupported checksum algorithm", http.StatusBadRequest)
+				return
+			}
+			wantSum = sum
+			body = io.TeeReader(r.Body, hasher)
+		}
+
+		chunkNumber := nextChunkNumber(dir)
+		chunkPath := fmt.Sprintf("%s/%d", dir, chunkNumber)
+
+		chunkFile, err := os.Create(chunkPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer chunkFile.Close()
+
+		written, err := io.Copy(chunkFile, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if wantSum != "" {
+			gotSum := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+			if gotSum != wantSum {
+				os.Remove(chunkPath)
+				http.Error(w, "checksum mismatch", 460)
+				return
+			}
+		}
+
+		// Record the chunk in the same manifest FSChunkStore uses, so
+		// store.Finalize can assemble this upload once it's complete.
+		if err := recordChunkSize(dir, chunkNumber, written); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// TotalChunks is left unset: tus chunk counts aren't known upfront,
+		// so completeness is checked by total size instead (see
+		// validateUploadComplete).
+		meta := UploadMeta{
+			UploadID:      upload.UploadID,
+			TotalFileSize: upload.Length,
+			Filename:      upload.Metadata["filename"],
+			CreatedAt:     upload.CreatedAt,
+		}
+		if err := store.SaveMeta(meta); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		upload.Offset += written
+		if err := saveTusUpload(dir, upload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Record activity so the janitor knows this upload is still alive.
+		if err := saveSessionState(uploadID, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if upload.Offset == upload.Length {
+			filename := upload.Metadata["filename"]
+			if filename == "" {
+				filename = upload.UploadID
+			}
+			if err := CompleteChunk(upload.UploadID, filename, ""); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		setTusHeaders(w)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handleTusDelete implements the tus termination extension: DELETE /files/{id}.
+func handleTusDelete(uploadID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := fmt.Sprintf("%s/%s", uploadDir, uploadID)
+
+		if err := os.RemoveAll(dir); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sessionManager.Forget(uploadID)
+
+		setTusHeaders(w)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handleTusOptions advertises protocol support, per the tus discovery mechanism.
+func handleTusOptions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setTusHeaders(w)
+		w.Header().Set("Tus-Version", tusResumableVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxChunkSize*1000, 10))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// handleTusFiles routes /files/{id} requests to the appropriate tus handler
+// based on the HTTP method.
+func handleTusFiles() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploadID := strings.TrimPrefix(r.URL.Path, "/files/")
+		if uploadID == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodHead:
+			handleTusHead(uploadID).ServeHTTP(w, r)
+		case http.MethodPatch:
+			handleTusPatch(uploadID).ServeHTTP(w, r)
+		case http.MethodDelete:
+			handleTusDelete(uploadID).ServeHTTP(w, r)
+		case http.MethodOptions:
+			handleTusOptions().ServeHTTP(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+}
+
+func saveTusUpload(dir string, upload *tusUpload) error {
+	f, err := os.Create(dir + "/info.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(upload)
+}
+
+func loadTusUpload(dir string) (*tusUpload, error) {
+	f, err := os.Open(dir + "/info.json")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var upload tusUpload
+	if err := json.NewDecoder(f).Decode(&upload); err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// nextChunkNumber returns the next free numbered chunk file in dir, skipping
+// info.json. Chunks written through the tus PATCH handler reuse the same
+// numbered-file layout as the multipart flow so RebuildFile can concatenate
+// either kind of upload.
+func nextChunkNumber(dir string) int32 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	var max int32 = -1
+	for _, entry := range entries {
+		n, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		if int32(n) > max {
+			max = int32(n)
+		}
+	}
+
+	return max + 1
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header, a comma
+// separated list of "key base64Value" pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, " ", 2)
+		key := parts[0]
+
+		var value string
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("failed decoding metadata value for %s %w", key, err)
+			}
+			value = string(decoded)
+		}
+
+		metadata[key] = value
+	}
+
+	return metadata, nil
+}
+
+// parseUploadChecksum splits an Upload-Checksum header of the form
+// "algorithm base64digest" into its parts.
+func parseUploadChecksum(header string) (algo, sum string, err error) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid Upload-Checksum header %q", header)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func generateUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed generating upload id %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}